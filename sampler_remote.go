@@ -15,7 +15,11 @@
 package jaeger
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"net/url"
 	"sync"
 	"sync/atomic"
@@ -23,13 +27,223 @@ import (
 
 	"github.com/uber/jaeger-client-go/log"
 	"github.com/uber/jaeger-client-go/thrift-gen/sampling"
-	"github.com/uber/jaeger-client-go/utils"
 )
 
 const (
 	defaultSamplingRefreshInterval = time.Minute
+	defaultMaxOperations           = 2000
+
+	// DefaultSamplingServerURL is the default address of jaeger-agent's HTTP sampling server
+	DefaultSamplingServerURL = "http://localhost:5778/sampling"
+
+	initialSyncMinBackoff = 100 * time.Millisecond
+	initialSyncMaxBackoff = 2 * time.Second
 )
 
+// SamplerOption is a function that sets some option on the sampler
+type SamplerOption func(options *samplerOptions)
+
+// SamplerOptions is a factory for all available SamplerOption's
+var SamplerOptions samplerOptionsFactory
+
+type samplerOptionsFactory struct{}
+
+// Metrics creates a SamplerOption that initializes Metrics on the sampler,
+// which is used to emit statistics about the performance of the sampler.
+func (samplerOptionsFactory) Metrics(m *Metrics) SamplerOption {
+	return func(options *samplerOptions) {
+		options.metrics = m
+	}
+}
+
+// MaxOperations creates a SamplerOption that sets the maximum number of
+// operations the AdaptiveSampler will keep track of.
+func (samplerOptionsFactory) MaxOperations(maxOperations int) SamplerOption {
+	return func(options *samplerOptions) {
+		options.maxOperations = maxOperations
+	}
+}
+
+// InitialSampler creates a SamplerOption that sets the initial sampler to use
+// before a remote sampling strategy is retrieved.
+func (samplerOptionsFactory) InitialSampler(sampler Sampler) SamplerOption {
+	return func(options *samplerOptions) {
+		options.sampler = sampler
+	}
+}
+
+// Logger creates a SamplerOption that sets the logger used by the sampler.
+func (samplerOptionsFactory) Logger(logger log.Logger) SamplerOption {
+	return func(options *samplerOptions) {
+		options.logger = logger
+	}
+}
+
+// SamplingServerURL creates a SamplerOption that sets the address of jaeger-agent's
+// HTTP sampling server. Ignored if a SamplingStrategyFetcher is also supplied.
+func (samplerOptionsFactory) SamplingServerURL(samplingServerURL string) SamplerOption {
+	return func(options *samplerOptions) {
+		options.samplingServerURL = samplingServerURL
+	}
+}
+
+// SamplingRefreshInterval creates a SamplerOption that sets how often the
+// sampler polls for an updated sampling strategy.
+func (samplerOptionsFactory) SamplingRefreshInterval(samplingRefreshInterval time.Duration) SamplerOption {
+	return func(options *samplerOptions) {
+		options.samplingRefreshInterval = samplingRefreshInterval
+	}
+}
+
+// SamplingStrategyFetcher creates a SamplerOption that initializes the fetcher used
+// to retrieve the raw sampling strategy response. The default fetcher polls
+// jaeger-agent's HTTP endpoint; a custom implementation can be supplied to poll
+// jaeger-collector over gRPC, read strategies from a file for testing, or run
+// behind custom auth.
+func (samplerOptionsFactory) SamplingStrategyFetcher(fetcher SamplingStrategyFetcher) SamplerOption {
+	return func(options *samplerOptions) {
+		options.samplingFetcher = fetcher
+	}
+}
+
+// SamplingStrategyParser creates a SamplerOption that initializes the parser used
+// to decode the raw bytes returned by the SamplingStrategyFetcher. The default
+// parser expects the JSON encoding used by jaeger-agent; a custom implementation
+// can be supplied to decode a different wire format, e.g. Protobuf.
+func (samplerOptionsFactory) SamplingStrategyParser(parser SamplingStrategyParser) SamplerOption {
+	return func(options *samplerOptions) {
+		options.samplingParser = parser
+	}
+}
+
+// OperationNameLateBinding creates a SamplerOption that forces "late binding" of the
+// operation name for the adaptive sampler on, regardless of what the polled strategy
+// reports. A sampling decision made with DeferredOperationName (e.g. by an HTTP handler
+// that resolves its route mid-request) is deferred and re-evaluated against the
+// per-operation strategy once the real operation name is set via
+// RemotelyControlledSampler.OnSetOperationName; IsSampled calls made with a known
+// operation name are unaffected. A polled strategy can also enable late binding on its
+// own without this option being set.
+func (samplerOptionsFactory) OperationNameLateBinding(enable bool) SamplerOption {
+	return func(options *samplerOptions) {
+		options.operationNameLateBinding = enable
+	}
+}
+
+// InitialSync creates a SamplerOption that makes NewRemotelyControlledSampler
+// synchronously fetch the sampling strategy at least once, retrying with exponential
+// backoff, before it returns. Without this option the sampler starts out under the
+// default sampler and only converges on the first SamplingRefreshInterval tick. timeout
+// bounds both the retries and the fetch itself, so a hung connect/read cannot keep
+// NewRemotelyControlledSampler from returning. On failure the sampler falls back to the
+// configured default sampler, and the error is reported via the SamplerQueryFailure
+// metric and the logger.
+func (samplerOptionsFactory) InitialSync(timeout time.Duration) SamplerOption {
+	return func(options *samplerOptions) {
+		options.initialSyncTimeout = timeout
+	}
+}
+
+type samplerOptions struct {
+	metrics                  *Metrics
+	sampler                  Sampler
+	logger                   log.Logger
+	maxOperations            int
+	samplingServerURL        string
+	samplingRefreshInterval  time.Duration
+	samplingFetcher          SamplingStrategyFetcher
+	samplingParser           SamplingStrategyParser
+	samplingManager          sampling.SamplingManager
+	operationNameLateBinding bool
+	initialSyncTimeout       time.Duration
+	strategyCacheDir         string
+	strategyCacheTTL         time.Duration
+}
+
+// SamplingStrategyFetcher is used to retrieve the raw sampling strategy response for
+// a service from a remote source, e.g. jaeger-agent's HTTP endpoint or
+// jaeger-collector's gRPC SamplingManager. RemotelyControlledSampler decodes the
+// returned bytes via the configured SamplingStrategyParser, so the fetcher itself
+// stays agnostic of the wire format. ctx bounds the fetch so a hung connect/read can't
+// block past the caller's deadline, e.g. during InitialSync.
+type SamplingStrategyFetcher interface {
+	Fetch(ctx context.Context, serviceName string) ([]byte, error)
+}
+
+// SamplingStrategyParser decodes the raw bytes returned by a SamplingStrategyFetcher
+// into a *sampling.SamplingStrategyResponse.
+type SamplingStrategyParser interface {
+	Parse(response []byte) (*sampling.SamplingStrategyResponse, error)
+}
+
+type httpSamplingStrategyFetcher struct {
+	serverURL string
+}
+
+func (f *httpSamplingStrategyFetcher) Fetch(ctx context.Context, serviceName string) ([]byte, error) {
+	v := url.Values{}
+	v.Set("service", serviceName)
+	uri := f.serverURL + "?" + v.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("StatusCode: %d, Body: %s", resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+type samplingStrategyParser struct{}
+
+func (p *samplingStrategyParser) Parse(response []byte) (*sampling.SamplingStrategyResponse, error) {
+	strategy := new(sampling.SamplingStrategyResponse)
+	if err := json.Unmarshal(response, strategy); err != nil {
+		return nil, err
+	}
+	return strategy, nil
+}
+
+// samplingManagerProxy adapts a SamplingStrategyFetcher/SamplingStrategyParser pair to
+// the sampling.SamplingManager interface expected by RemotelyControlledSampler, so that
+// pollController and updateSampler remain unaware of the transport in use.
+type samplingManagerProxy struct {
+	fetcher SamplingStrategyFetcher
+	parser  SamplingStrategyParser
+}
+
+func (m *samplingManagerProxy) GetSamplingStrategy(serviceName string) (*sampling.SamplingStrategyResponse, error) {
+	return m.GetSamplingStrategyWithContext(context.Background(), serviceName)
+}
+
+func (m *samplingManagerProxy) GetSamplingStrategyWithContext(ctx context.Context, serviceName string) (*sampling.SamplingStrategyResponse, error) {
+	body, err := m.fetcher.Fetch(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return m.parser.Parse(body)
+}
+
+// contextAwareSamplingManager is implemented by sampling.SamplingManager implementations
+// that can bound their fetch with a context. initialSync type-asserts for it so its
+// backoff deadline reaches the underlying transport instead of only bounding the number
+// of retries; managers that don't implement it (e.g. one supplied directly as a
+// sampling.SamplingManager) are still called through the plain interface.
+type contextAwareSamplingManager interface {
+	GetSamplingStrategyWithContext(ctx context.Context, serviceName string) (*sampling.SamplingStrategyResponse, error)
+}
+
 // -----------------------
 
 // RemotelyControlledSampler is a delegating sampler that polls a remote server
@@ -46,21 +260,23 @@ type RemotelyControlledSampler struct {
 	serviceName string
 	manager     sampling.SamplingManager
 	doneChan    chan *sync.WaitGroup
-}
 
-type httpSamplingManager struct {
-	serverURL string
+	// serverLateBinding mirrors the most recently polled strategy's
+	// OperationNameLateBinding flag exactly (never OR-latched against its own previous
+	// value), so a server that turns the directive back off takes effect on the next
+	// poll instead of being stuck on forever. It is combined with the
+	// OperationNameLateBinding SamplerOption, which forces late binding on regardless of
+	// what the server reports.
+	serverLateBinding bool
 }
 
-func (s *httpSamplingManager) GetSamplingStrategy(serviceName string) (*sampling.SamplingStrategyResponse, error) {
-	var out sampling.SamplingStrategyResponse
-	v := url.Values{}
-	v.Set("service", serviceName)
-	if err := utils.GetJSON(s.serverURL+"?"+v.Encode(), &out); err != nil {
-		return nil, err
-	}
-	return &out, nil
-}
+// DeferredOperationName is passed to IsSampled in place of the real operation name by
+// callers that don't know it yet (e.g. an HTTP handler that resolves its route
+// mid-request) and will call OnSetOperationName once they do. When OperationNameLateBinding
+// is in effect, only calls made with this sentinel are treated as deferred; IsSampled
+// calls made with any other operation name are charged against the per-operation budget
+// as usual, so spans whose operation name is already known are unaffected by late binding.
+const DeferredOperationName = ""
 
 // NewRemotelyControlledSampler creates a sampler that periodically pulls
 // the sampling strategy from an HTTP sampling server (e.g. jaeger-agent).
@@ -69,16 +285,59 @@ func NewRemotelyControlledSampler(
 	opts ...SamplerOption,
 ) *RemotelyControlledSampler {
 	options := applySamplerOptions(opts...)
+	manager := options.samplingManager
+	if manager == nil {
+		manager = &samplingManagerProxy{
+			fetcher: options.samplingFetcher,
+			parser:  options.samplingParser,
+		}
+	}
 	sampler := &RemotelyControlledSampler{
 		samplerOptions: options,
 		serviceName:    serviceName,
-		manager:        &httpSamplingManager{serverURL: options.samplingServerURL},
+		manager:        manager,
 		doneChan:       make(chan *sync.WaitGroup),
 	}
+	if options.strategyCacheDir != "" {
+		if cached := sampler.loadCachedStrategy(); cached != nil {
+			sampler.Lock()
+			sampler.applyStrategyLocked(cached)
+			sampler.Unlock()
+		}
+	}
+	if options.initialSyncTimeout > 0 {
+		sampler.initialSync(options.initialSyncTimeout)
+	}
 	go sampler.pollController()
 	return sampler
 }
 
+// initialSync retries updateSampler with exponential backoff until it succeeds or
+// timeout elapses, so the sampler has a chance to converge on the real sampling
+// strategy before NewRemotelyControlledSampler returns. Each attempt is itself bounded
+// by the overall deadline, so a single hung connect/read cannot block past timeout.
+// See SamplerOptions.InitialSync.
+func (s *RemotelyControlledSampler) initialSync(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	backoff := initialSyncMinBackoff
+	for {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		err := s.updateSamplerWithContext(ctx)
+		cancel()
+		if err == nil {
+			return
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			s.logger.Error("Timed out waiting for initial sampling strategy; falling back to the default sampler")
+			return
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > initialSyncMaxBackoff {
+			backoff = initialSyncMaxBackoff
+		}
+	}
+}
+
 func applySamplerOptions(opts ...SamplerOption) samplerOptions {
 	options := samplerOptions{}
 	for _, option := range opts {
@@ -102,6 +361,15 @@ func applySamplerOptions(opts ...SamplerOption) samplerOptions {
 	if options.samplingRefreshInterval <= 0 {
 		options.samplingRefreshInterval = defaultSamplingRefreshInterval
 	}
+	if options.samplingFetcher == nil {
+		options.samplingFetcher = &httpSamplingStrategyFetcher{serverURL: options.samplingServerURL}
+	}
+	if options.samplingParser == nil {
+		options.samplingParser = new(samplingStrategyParser)
+	}
+	if options.strategyCacheTTL <= 0 {
+		options.strategyCacheTTL = defaultStrategyCacheTTL
+	}
 	return options
 }
 
@@ -109,9 +377,41 @@ func applySamplerOptions(opts ...SamplerOption) samplerOptions {
 func (s *RemotelyControlledSampler) IsSampled(id TraceID, operation string) (bool, []Tag) {
 	s.RLock()
 	defer s.RUnlock()
+	if operation == DeferredOperationName && (s.operationNameLateBinding || s.serverLateBinding) {
+		// The caller doesn't know the real operation name yet, so this decision must not
+		// consume the per-operation adaptive/rate-limiting budget; OnSetOperationName
+		// makes the authoritative decision once the real operation name is set. Calls
+		// made with a known operation name always go through the normal path below, so
+		// enabling late binding does not by itself change their sampling rate.
+		return true, nil
+	}
+	return s.sampler.IsSampled(id, operation)
+}
+
+// OnSetOperationName re-evaluates a sampling decision once a span's real operation
+// name becomes known. It is the counterpart to IsSampled(id, DeferredOperationName):
+// when late binding is in effect, that earlier decision was only provisional, and the
+// tracer calls OnSetOperationName from SetOperationName to get the final decision from
+// the per-operation adaptive strategy. It is a no-op, returning false, if late binding
+// is not currently in effect (neither the OperationNameLateBinding SamplerOption nor the
+// most recently polled strategy enabled it).
+func (s *RemotelyControlledSampler) OnSetOperationName(id TraceID, operation string) (bool, []Tag) {
+	s.RLock()
+	defer s.RUnlock()
+	if !s.operationNameLateBinding && !s.serverLateBinding {
+		return false, nil
+	}
 	return s.sampler.IsSampled(id, operation)
 }
 
+// samplingManagerCloser is implemented by sampling.SamplingManager implementations that
+// own a resource (e.g. a dialed gRPC connection) which must be released on Close(). It is
+// checked via a type assertion so managers that don't need cleanup, such as
+// samplingManagerProxy, need not implement it.
+type samplingManagerCloser interface {
+	Close() error
+}
+
 // Close implements Close() of Sampler.
 func (s *RemotelyControlledSampler) Close() {
 	if swapped := atomic.CompareAndSwapInt64(&s.closed, 0, 1); !swapped {
@@ -123,6 +423,12 @@ func (s *RemotelyControlledSampler) Close() {
 	wg.Add(1)
 	s.doneChan <- &wg
 	wg.Wait()
+
+	if closer, ok := s.manager.(samplingManagerCloser); ok {
+		if err := closer.Close(); err != nil {
+			s.logger.Error(fmt.Sprintf("Failed to close sampling manager: %v", err))
+		}
+	}
 }
 
 // Equal implements Equal() of Sampler.
@@ -169,32 +475,70 @@ func (s *RemotelyControlledSampler) setSampler(sampler Sampler) {
 	s.sampler = sampler
 }
 
-func (s *RemotelyControlledSampler) updateSampler() {
+func (s *RemotelyControlledSampler) updateSampler() error {
 	res, err := s.manager.GetSamplingStrategy(s.serviceName)
+	return s.applySamplerUpdate(res, err)
+}
+
+// updateSamplerWithContext behaves like updateSampler, but when the configured manager
+// implements contextAwareSamplingManager, bounds the fetch itself by ctx, so a single
+// hung connect/read cannot block past ctx's deadline. Used by initialSync.
+func (s *RemotelyControlledSampler) updateSamplerWithContext(ctx context.Context) error {
+	manager, ok := s.manager.(contextAwareSamplingManager)
+	if !ok {
+		return s.updateSampler()
+	}
+	res, err := manager.GetSamplingStrategyWithContext(ctx, s.serviceName)
+	return s.applySamplerUpdate(res, err)
+}
+
+func (s *RemotelyControlledSampler) applySamplerUpdate(res *sampling.SamplingStrategyResponse, err error) error {
 	if err != nil {
 		s.metrics.SamplerQueryFailure.Inc(1)
 		s.logger.Infof("Unable to query sampling strategy: %v", err)
-		return
+		return err
 	}
+	s.metrics.SamplerRetrieved.Inc(1)
 	s.Lock()
-	defer s.Unlock()
+	if err := s.applyStrategyLocked(res); err != nil {
+		s.Unlock()
+		s.metrics.SamplerUpdateFailure.Inc(1)
+		s.logger.Infof("Unable to handle sampling strategy response %+v. Got error: %v", res, err)
+		return err
+	}
+	s.Unlock()
 
-	s.metrics.SamplerRetrieved.Inc(1)
+	s.metrics.SamplerUpdated.Inc(1)
+	// Writing to disk is its own I/O, so it happens after the write lock is released:
+	// holding it here would stall every concurrent IsSampled/OnSetOperationName caller
+	// (which only need an RLock) for the duration of the cache file write/rename.
+	s.saveCachedStrategy(res)
+	return nil
+}
+
+// applyStrategyLocked installs res as the sampler's current strategy.
+// NB: this function should only be called while holding a Write lock
+func (s *RemotelyControlledSampler) applyStrategyLocked(res *sampling.SamplingStrategyResponse) error {
 	if strategies := res.GetOperationSampling(); strategies != nil {
 		s.updateAdaptiveSampler(strategies)
-	} else {
-		err = s.updateRateLimitingOrProbabilisticSampler(res)
+		return nil
 	}
-	if err != nil {
-		s.metrics.SamplerUpdateFailure.Inc(1)
-		s.logger.Infof("Unable to handle sampling strategy response %+v. Got error: %v", res, err)
-		return
+	if err := s.updateRateLimitingOrProbabilisticSampler(res); err != nil {
+		return err
 	}
-	s.metrics.SamplerUpdated.Inc(1)
+	// A non-adaptive strategy carries no late-binding directive.
+	s.serverLateBinding = false
+	return nil
 }
 
 // NB: this function should only be called while holding a Write lock
 func (s *RemotelyControlledSampler) updateAdaptiveSampler(strategies *sampling.PerOperationSamplingStrategies) {
+	// Late binding is a concern of RemotelyControlledSampler's own IsSampled/
+	// OnSetOperationName dispatch (see OperationNameLateBinding), not of the adaptive
+	// sampler itself, so it is tracked here rather than threaded into adaptiveSampler.
+	// Assigned directly, not OR-latched against its previous value, so a server that
+	// turns the directive back off is honored on the next poll.
+	s.serverLateBinding = strategies.GetOperationNameLateBinding()
 	if adaptiveSampler, ok := s.sampler.(*adaptiveSampler); ok {
 		adaptiveSampler.update(strategies)
 	} else {
@@ -216,4 +560,4 @@ func (s *RemotelyControlledSampler) updateRateLimitingOrProbabilisticSampler(res
 		s.sampler = newSampler
 	}
 	return nil
-}
\ No newline at end of file
+}
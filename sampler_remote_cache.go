@@ -0,0 +1,103 @@
+// Copyright (c) 2020 The Jaeger Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jaeger
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/uber/jaeger-client-go/thrift-gen/sampling"
+)
+
+const defaultStrategyCacheTTL = 24 * time.Hour
+
+// StrategyCache creates a SamplerOption that persists the last-known sampling
+// strategy to a file under dir after every successful poll, and loads it back as the
+// sampler's initial strategy on construction if a fresh-enough entry exists, so a
+// restart doesn't fall back to the hard-coded default sampler while waiting on the
+// first poll. Multiple services may safely share the same dir: cache files are keyed
+// by a hash of the service name.
+func (samplerOptionsFactory) StrategyCache(dir string) SamplerOption {
+	return func(options *samplerOptions) {
+		options.strategyCacheDir = dir
+	}
+}
+
+// StrategyCacheTTL creates a SamplerOption that bounds how stale a strategy loaded
+// from the StrategyCache directory may be before it is ignored. Defaults to 24h.
+func (samplerOptionsFactory) StrategyCacheTTL(ttl time.Duration) SamplerOption {
+	return func(options *samplerOptions) {
+		options.strategyCacheTTL = ttl
+	}
+}
+
+type cachedStrategy struct {
+	CachedAt time.Time                          `json:"cachedAt"`
+	Response *sampling.SamplingStrategyResponse `json:"response"`
+}
+
+func (s *RemotelyControlledSampler) strategyCacheFile() string {
+	h := sha256.Sum256([]byte(s.serviceName))
+	return filepath.Join(s.strategyCacheDir, fmt.Sprintf("%x.json", h))
+}
+
+// loadCachedStrategy reads the last-known sampling strategy from the StrategyCache
+// directory, if one was configured and a fresh-enough entry exists for this service.
+func (s *RemotelyControlledSampler) loadCachedStrategy() *sampling.SamplingStrategyResponse {
+	data, err := ioutil.ReadFile(s.strategyCacheFile())
+	if err != nil {
+		return nil
+	}
+	var cached cachedStrategy
+	if err := json.Unmarshal(data, &cached); err != nil {
+		s.logger.Infof("Unable to parse cached sampling strategy: %v", err)
+		return nil
+	}
+	if time.Since(cached.CachedAt) > s.strategyCacheTTL {
+		return nil
+	}
+	return cached.Response
+}
+
+// saveCachedStrategy atomically writes res to the StrategyCache directory, if one
+// was configured.
+func (s *RemotelyControlledSampler) saveCachedStrategy(res *sampling.SamplingStrategyResponse) {
+	if s.strategyCacheDir == "" {
+		return
+	}
+	data, err := json.Marshal(cachedStrategy{CachedAt: time.Now(), Response: res})
+	if err != nil {
+		s.logger.Infof("Unable to marshal sampling strategy for on-disk cache: %v", err)
+		return
+	}
+	if err := os.MkdirAll(s.strategyCacheDir, 0755); err != nil {
+		s.logger.Infof("Unable to create sampling strategy cache directory: %v", err)
+		return
+	}
+	path := s.strategyCacheFile()
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		s.logger.Infof("Unable to write sampling strategy cache file: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		s.logger.Infof("Unable to finalize sampling strategy cache file: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2020 The Jaeger Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jaeger
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/jaeger-client-go/log"
+	"github.com/uber/jaeger-client-go/thrift-gen/sampling"
+)
+
+func tempCacheDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "jaeger-strategy-cache-test")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func newCacheTestSampler(t *testing.T) *RemotelyControlledSampler {
+	return &RemotelyControlledSampler{
+		samplerOptions: samplerOptions{
+			strategyCacheDir: tempCacheDir(t),
+			strategyCacheTTL: time.Hour,
+			logger:           log.NullLogger,
+		},
+		serviceName: "cache-test-service",
+	}
+}
+
+func TestStrategyCache_MissWhenNoFileExists(t *testing.T) {
+	s := newCacheTestSampler(t)
+	assert.Nil(t, s.loadCachedStrategy())
+}
+
+func TestStrategyCache_CreatesDirectoryOnFirstWrite(t *testing.T) {
+	dir := tempCacheDir(t)
+	notYetCreated := dir + "/nested/cache-dir"
+	s := &RemotelyControlledSampler{
+		samplerOptions: samplerOptions{
+			strategyCacheDir: notYetCreated,
+			strategyCacheTTL: time.Hour,
+			logger:           log.NullLogger,
+		},
+		serviceName: "cache-test-service",
+	}
+	want := &sampling.SamplingStrategyResponse{
+		ProbabilisticSampling: &sampling.ProbabilisticSamplingStrategy{SamplingRate: 0.33},
+	}
+
+	s.saveCachedStrategy(want)
+
+	got := s.loadCachedStrategy()
+	require.NotNil(t, got, "saveCachedStrategy must create strategyCacheDir on first use instead of silently failing forever")
+	assert.Equal(t, want.ProbabilisticSampling.SamplingRate, got.ProbabilisticSampling.SamplingRate)
+}
+
+func TestStrategyCache_HitAfterSave(t *testing.T) {
+	s := newCacheTestSampler(t)
+	want := &sampling.SamplingStrategyResponse{
+		ProbabilisticSampling: &sampling.ProbabilisticSamplingStrategy{SamplingRate: 0.25},
+	}
+
+	s.saveCachedStrategy(want)
+
+	got := s.loadCachedStrategy()
+	require.NotNil(t, got)
+	assert.Equal(t, want.ProbabilisticSampling.SamplingRate, got.ProbabilisticSampling.SamplingRate)
+}
+
+func TestStrategyCache_MissWhenExpired(t *testing.T) {
+	s := newCacheTestSampler(t)
+	s.strategyCacheTTL = time.Millisecond
+
+	s.saveCachedStrategy(&sampling.SamplingStrategyResponse{
+		ProbabilisticSampling: &sampling.ProbabilisticSamplingStrategy{SamplingRate: 0.25},
+	})
+	time.Sleep(5 * time.Millisecond)
+
+	assert.Nil(t, s.loadCachedStrategy(), "an entry older than strategyCacheTTL must be ignored")
+}
+
+func TestStrategyCache_MissOnCorruptFile(t *testing.T) {
+	s := newCacheTestSampler(t)
+	require.NoError(t, ioutil.WriteFile(s.strategyCacheFile(), []byte("not json"), 0644))
+
+	assert.Nil(t, s.loadCachedStrategy())
+}
+
+func TestStrategyCache_DifferentServiceNamesUseDifferentFiles(t *testing.T) {
+	dir := tempCacheDir(t)
+	a := &RemotelyControlledSampler{
+		samplerOptions: samplerOptions{strategyCacheDir: dir, strategyCacheTTL: time.Hour, logger: log.NullLogger},
+		serviceName:    "service-a",
+	}
+	b := &RemotelyControlledSampler{
+		samplerOptions: samplerOptions{strategyCacheDir: dir, strategyCacheTTL: time.Hour, logger: log.NullLogger},
+		serviceName:    "service-b",
+	}
+
+	a.saveCachedStrategy(&sampling.SamplingStrategyResponse{
+		ProbabilisticSampling: &sampling.ProbabilisticSamplingStrategy{SamplingRate: 0.1},
+	})
+
+	assert.NotEqual(t, a.strategyCacheFile(), b.strategyCacheFile())
+	assert.Nil(t, b.loadCachedStrategy())
+}
+
+func TestStrategyCache_NoopWhenDirNotConfigured(t *testing.T) {
+	s := &RemotelyControlledSampler{
+		samplerOptions: samplerOptions{logger: log.NullLogger},
+		serviceName:    "cache-test-service",
+	}
+	// Must not attempt to write to an empty/relative path.
+	s.saveCachedStrategy(&sampling.SamplingStrategyResponse{
+		ProbabilisticSampling: &sampling.ProbabilisticSamplingStrategy{SamplingRate: 0.1},
+	})
+}
+
+func TestCachedStrategy_JSONRoundTrip(t *testing.T) {
+	cached := cachedStrategy{
+		CachedAt: time.Now(),
+		Response: &sampling.SamplingStrategyResponse{
+			ProbabilisticSampling: &sampling.ProbabilisticSamplingStrategy{SamplingRate: 0.5},
+		},
+	}
+	data, err := json.Marshal(cached)
+	require.NoError(t, err)
+
+	var got cachedStrategy
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, cached.Response.ProbabilisticSampling.SamplingRate, got.Response.ProbabilisticSampling.SamplingRate)
+}
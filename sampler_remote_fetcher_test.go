@@ -0,0 +1,79 @@
+// Copyright (c) 2020 The Jaeger Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jaeger
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPSamplingStrategyFetcher_Fetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "my-service", r.URL.Query().Get("service"))
+		w.Write([]byte(`{"strategyType":0}`))
+	}))
+	defer server.Close()
+
+	fetcher := &httpSamplingStrategyFetcher{serverURL: server.URL}
+	body, err := fetcher.Fetch(context.Background(), "my-service")
+	require.NoError(t, err)
+	assert.Equal(t, `{"strategyType":0}`, string(body))
+}
+
+func TestHTTPSamplingStrategyFetcher_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("boom"))
+	}))
+	defer server.Close()
+
+	fetcher := &httpSamplingStrategyFetcher{serverURL: server.URL}
+	_, err := fetcher.Fetch(context.Background(), "my-service")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "500")
+}
+
+func TestSamplingStrategyParser_Parse(t *testing.T) {
+	parser := &samplingStrategyParser{}
+	res, err := parser.Parse([]byte(`{"probabilisticSampling":{"samplingRate":0.75}}`))
+	require.NoError(t, err)
+	require.NotNil(t, res.ProbabilisticSampling)
+	assert.Equal(t, 0.75, res.ProbabilisticSampling.SamplingRate)
+}
+
+func TestSamplingStrategyParser_InvalidJSON(t *testing.T) {
+	parser := &samplingStrategyParser{}
+	_, err := parser.Parse([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestSamplingManagerProxy_WiresFetcherAndParser(t *testing.T) {
+	fetcher := &flakyFetcher{failures: 0}
+	proxy := &samplingManagerProxy{
+		fetcher: fetcher,
+		parser:  fixedStrategyParser{},
+	}
+
+	res, err := proxy.GetSamplingStrategy("my-service")
+	require.NoError(t, err)
+	require.NotNil(t, res.ProbabilisticSampling)
+	assert.Equal(t, 0.5, res.ProbabilisticSampling.SamplingRate)
+	assert.Equal(t, 1, fetcher.calls)
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2020 The Jaeger Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jaeger
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"google.golang.org/grpc"
+
+	"github.com/jaegertracing/jaeger-idl/proto-gen/api_v2"
+	"github.com/uber/jaeger-client-go/thrift-gen/sampling"
+)
+
+// SamplingGRPCConn creates a SamplerOption that makes RemotelyControlledSampler poll
+// jaeger-collector's SamplingManager gRPC service (api_v2/sampling.proto) directly,
+// instead of jaeger-agent's HTTP JSON endpoint. This lets clients running without an
+// agent sidecar fetch sampling strategies straight from the collector, with TLS,
+// retries and keepalive handled by the supplied *grpc.ClientConn. pollController and
+// updateSampler are unaffected; only the manager wiring changes.
+func (samplerOptionsFactory) SamplingGRPCConn(cc *grpc.ClientConn) SamplerOption {
+	return func(options *samplerOptions) {
+		// The caller owns cc and is responsible for closing it, so RemotelyControlledSampler
+		// must not close it out from under them on Sampler.Close().
+		options.samplingManager = &grpcSamplingManager{client: api_v2.NewSamplingManagerClient(cc)}
+	}
+}
+
+// SamplingRefreshEndpoint creates a SamplerOption that dials addr and polls
+// jaeger-collector's SamplingManager gRPC service at that address. It is a
+// convenience wrapper around SamplingGRPCConn for callers that don't need to
+// customize the underlying *grpc.ClientConn (TLS, interceptors, etc).
+func (samplerOptionsFactory) SamplingRefreshEndpoint(addr string, dialOpts ...grpc.DialOption) SamplerOption {
+	return func(options *samplerOptions) {
+		cc, err := grpc.Dial(addr, dialOpts...)
+		if err != nil {
+			// Deferred to the first poll, where it surfaces through the usual
+			// SamplerQueryFailure metric and logger instead of panicking here.
+			options.samplingManager = &erroringSamplingManager{err: err}
+			return
+		}
+		options.samplingManager = newGRPCSamplingManager(cc)
+	}
+}
+
+// grpcSamplingManager implements sampling.SamplingManager by querying
+// jaeger-collector's SamplingManager gRPC service.
+type grpcSamplingManager struct {
+	client api_v2.SamplingManagerClient
+
+	// cc is non-nil only when this grpcSamplingManager dialed its own connection (the
+	// SamplingRefreshEndpoint option), in which case Close() tears it down. When the conn
+	// came from the caller via SamplingGRPCConn, cc is left nil and Close() is a no-op:
+	// the caller owns that connection's lifecycle.
+	cc *grpc.ClientConn
+}
+
+func newGRPCSamplingManager(cc *grpc.ClientConn) *grpcSamplingManager {
+	return &grpcSamplingManager{client: api_v2.NewSamplingManagerClient(cc), cc: cc}
+}
+
+// Close tears down the gRPC connection dialed by SamplingRefreshEndpoint. It is called
+// from RemotelyControlledSampler.Close() via the samplingManagerCloser interface.
+func (m *grpcSamplingManager) Close() error {
+	if m.cc == nil {
+		return nil
+	}
+	return m.cc.Close()
+}
+
+func (m *grpcSamplingManager) GetSamplingStrategy(serviceName string) (*sampling.SamplingStrategyResponse, error) {
+	return m.GetSamplingStrategyWithContext(context.Background(), serviceName)
+}
+
+// GetSamplingStrategyWithContext is the contextAwareSamplingManager hook that lets
+// RemotelyControlledSampler.initialSync bound this call by its backoff deadline instead
+// of the unbounded context.Background() used by the regular poll path.
+func (m *grpcSamplingManager) GetSamplingStrategyWithContext(ctx context.Context, serviceName string) (*sampling.SamplingStrategyResponse, error) {
+	resp, err := m.client.GetSamplingStrategy(ctx, &api_v2.SamplingStrategyParametersRequest{
+		ServiceName: serviceName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return grpcToThriftStrategyResponse(resp)
+}
+
+// grpcToThriftStrategyResponse translates jaeger-collector's protobuf
+// SamplingStrategyResponse into the thrift-gen representation used throughout
+// RemotelyControlledSampler, so pollController and updateSampler don't need to know
+// which transport produced the strategy. It returns an error on a malformed response
+// (e.g. a MaxTracesPerSecond that overflows int16), matching the HTTP/JSON path, where
+// encoding/json already rejects an out-of-range value for the same field.
+func grpcToThriftStrategyResponse(resp *api_v2.SamplingStrategyResponse) (*sampling.SamplingStrategyResponse, error) {
+	out := &sampling.SamplingStrategyResponse{
+		StrategyType: sampling.SamplingStrategyType(resp.GetStrategyType()),
+	}
+	if p := resp.GetProbabilisticSampling(); p != nil {
+		out.ProbabilisticSampling = &sampling.ProbabilisticSamplingStrategy{
+			SamplingRate: p.GetSamplingRate(),
+		}
+	}
+	if r := resp.GetRateLimitingSampling(); r != nil {
+		maxTracesPerSecond := r.GetMaxTracesPerSecond()
+		if maxTracesPerSecond < 0 || maxTracesPerSecond > math.MaxInt16 {
+			return nil, fmt.Errorf("MaxTracesPerSecond %d overflows int16", maxTracesPerSecond)
+		}
+		out.RateLimitingSampling = &sampling.RateLimitingSamplingStrategy{
+			MaxTracesPerSecond: int16(maxTracesPerSecond),
+		}
+	}
+	if o := resp.GetOperationSampling(); o != nil {
+		perOp := make([]*sampling.OperationSamplingStrategy, 0, len(o.GetPerOperationStrategies()))
+		for _, s := range o.GetPerOperationStrategies() {
+			perOp = append(perOp, &sampling.OperationSamplingStrategy{
+				Operation: s.GetOperation(),
+				ProbabilisticSampling: &sampling.ProbabilisticSamplingStrategy{
+					SamplingRate: s.GetProbabilisticSampling().GetSamplingRate(),
+				},
+			})
+		}
+		out.OperationSampling = &sampling.PerOperationSamplingStrategies{
+			DefaultSamplingProbability:       o.GetDefaultSamplingProbability(),
+			DefaultLowerBoundTracesPerSecond: o.GetDefaultLowerBoundTracesPerSecond(),
+			PerOperationStrategies:           perOp,
+			OperationNameLateBinding:         o.GetOperationNameLateBinding(),
+		}
+	}
+	return out, nil
+}
+
+// erroringSamplingManager always returns err from GetSamplingStrategy. It is used when
+// a SamplerOption fails to establish a connection at construction time, so the failure
+// surfaces through the normal polling/metrics path instead of a panic.
+type erroringSamplingManager struct {
+	err error
+}
+
+func (m *erroringSamplingManager) GetSamplingStrategy(serviceName string) (*sampling.SamplingStrategyResponse, error) {
+	return nil, m.err
+}
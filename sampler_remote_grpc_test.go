@@ -0,0 +1,126 @@
+// Copyright (c) 2020 The Jaeger Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jaeger
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+
+	"github.com/jaegertracing/jaeger-idl/proto-gen/api_v2"
+)
+
+// fakeSamplingManagerClient is a hand-rolled fake of api_v2.SamplingManagerClient, so the
+// proto->thrift translation in grpcToThriftStrategyResponse can be exercised without a
+// real jaeger-collector.
+type fakeSamplingManagerClient struct {
+	resp    *api_v2.SamplingStrategyResponse
+	err     error
+	lastReq *api_v2.SamplingStrategyParametersRequest
+}
+
+func (f *fakeSamplingManagerClient) GetSamplingStrategy(ctx context.Context, in *api_v2.SamplingStrategyParametersRequest, opts ...grpc.CallOption) (*api_v2.SamplingStrategyResponse, error) {
+	f.lastReq = in
+	return f.resp, f.err
+}
+
+func TestGRPCSamplingManager_RoundTrip(t *testing.T) {
+	fake := &fakeSamplingManagerClient{
+		resp: &api_v2.SamplingStrategyResponse{
+			ProbabilisticSampling: &api_v2.ProbabilisticSamplingStrategy{SamplingRate: 0.42},
+		},
+	}
+	m := &grpcSamplingManager{client: fake}
+
+	res, err := m.GetSamplingStrategy("my-service")
+	require.NoError(t, err)
+	require.NotNil(t, res.ProbabilisticSampling)
+	assert.Equal(t, 0.42, res.ProbabilisticSampling.SamplingRate)
+	require.NotNil(t, fake.lastReq)
+	assert.Equal(t, "my-service", fake.lastReq.ServiceName)
+}
+
+func TestGRPCSamplingManager_PropagatesClientError(t *testing.T) {
+	fake := &fakeSamplingManagerClient{err: assert.AnError}
+	m := &grpcSamplingManager{client: fake}
+
+	_, err := m.GetSamplingStrategy("my-service")
+	assert.Equal(t, assert.AnError, err)
+}
+
+func TestGrpcToThriftStrategyResponse_CopiesOperationNameLateBinding(t *testing.T) {
+	resp := &api_v2.SamplingStrategyResponse{
+		OperationSampling: &api_v2.PerOperationSamplingStrategies{
+			OperationNameLateBinding: true,
+		},
+	}
+
+	out, err := grpcToThriftStrategyResponse(resp)
+	require.NoError(t, err)
+	require.NotNil(t, out.OperationSampling)
+	assert.True(t, out.OperationSampling.GetOperationNameLateBinding(), "the server's late-binding directive must survive the gRPC transport, not just HTTP/JSON")
+}
+
+func TestGrpcToThriftStrategyResponse_RejectsOverflowingRateLimit(t *testing.T) {
+	resp := &api_v2.SamplingStrategyResponse{
+		RateLimitingSampling: &api_v2.RateLimitingSamplingStrategy{MaxTracesPerSecond: math.MaxInt32},
+	}
+
+	_, err := grpcToThriftStrategyResponse(resp)
+	assert.Error(t, err, "a MaxTracesPerSecond that overflows int16 must be rejected, not silently wrapped, matching the HTTP/JSON path")
+}
+
+func TestGrpcSamplingManager_CloseClosesSelfDialedConn(t *testing.T) {
+	cc, err := grpc.Dial("127.0.0.1:0", grpc.WithInsecure())
+	require.NoError(t, err)
+	m := newGRPCSamplingManager(cc)
+
+	require.NoError(t, m.Close())
+	assert.Equal(t, connectivity.Shutdown, cc.GetState())
+}
+
+func TestGrpcSamplingManager_CloseLeavesCallerSuppliedConnOpen(t *testing.T) {
+	cc, err := grpc.Dial("127.0.0.1:0", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer cc.Close()
+
+	opts := &samplerOptions{}
+	SamplerOptions.SamplingGRPCConn(cc)(opts)
+	m, ok := opts.samplingManager.(*grpcSamplingManager)
+	require.True(t, ok)
+
+	require.NoError(t, m.Close())
+	assert.NotEqual(t, connectivity.Shutdown, cc.GetState(), "Close() must not tear down a *grpc.ClientConn supplied via SamplingGRPCConn; the caller owns its lifecycle")
+}
+
+func TestSamplingRefreshEndpoint_DialErrorFallsBackToErroringManager(t *testing.T) {
+	opts := &samplerOptions{}
+	opt := SamplerOptions.SamplingRefreshEndpoint(
+		"invalid-target",
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithTimeout(50*time.Millisecond),
+	)
+	opt(opts)
+
+	_, ok := opts.samplingManager.(*erroringSamplingManager)
+	assert.True(t, ok, "a dial error must surface through erroringSamplingManager instead of panicking or leaving samplingManager nil")
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2020 The Jaeger Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jaeger
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/uber/jaeger-client-go/thrift-gen/sampling"
+)
+
+// hangingFetcher never returns on its own; it only unblocks when its ctx is canceled, so
+// tests can assert that InitialSync bounds the underlying fetch rather than just the
+// number of retries.
+type hangingFetcher struct{}
+
+func (f *hangingFetcher) Fetch(ctx context.Context, serviceName string) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestInitialSync_BoundsHungFetchByDeadline(t *testing.T) {
+	start := time.Now()
+	sampler := NewRemotelyControlledSampler(
+		"test-service",
+		SamplerOptions.SamplingStrategyFetcher(&hangingFetcher{}),
+		SamplerOptions.InitialSync(200*time.Millisecond),
+	)
+	defer sampler.Close()
+
+	assert.Less(t, time.Since(start), time.Second, "a hung fetch must not block NewRemotelyControlledSampler past the InitialSync timeout")
+}
+
+// flakyFetcher fails the first `failures` calls, then succeeds, so tests can assert that
+// initialSync's backoff loop actually retries.
+type flakyFetcher struct {
+	failures int
+	calls    int
+}
+
+func (f *flakyFetcher) Fetch(ctx context.Context, serviceName string) ([]byte, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, errors.New("transient fetch error")
+	}
+	return []byte("ok"), nil
+}
+
+type fixedStrategyParser struct{}
+
+func (fixedStrategyParser) Parse(response []byte) (*sampling.SamplingStrategyResponse, error) {
+	return &sampling.SamplingStrategyResponse{
+		ProbabilisticSampling: &sampling.ProbabilisticSamplingStrategy{SamplingRate: 0.5},
+	}, nil
+}
+
+func TestInitialSync_RetriesWithBackoffUntilSuccess(t *testing.T) {
+	fetcher := &flakyFetcher{failures: 2}
+	start := time.Now()
+	sampler := NewRemotelyControlledSampler(
+		"test-service",
+		SamplerOptions.SamplingStrategyFetcher(fetcher),
+		SamplerOptions.SamplingStrategyParser(fixedStrategyParser{}),
+		SamplerOptions.InitialSync(time.Second),
+	)
+	defer sampler.Close()
+
+	assert.GreaterOrEqual(t, fetcher.calls, 3, "initialSync should retry until the fetcher succeeds")
+	assert.Less(t, time.Since(start), time.Second)
+}
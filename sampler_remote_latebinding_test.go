@@ -0,0 +1,124 @@
+// Copyright (c) 2020 The Jaeger Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jaeger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/uber/jaeger-client-go/log"
+	"github.com/uber/jaeger-client-go/thrift-gen/sampling"
+)
+
+// countingSampler records how many times IsSampled was called, so tests can assert
+// that a given code path does or does not consume the underlying sampler's budget.
+type countingSampler struct {
+	calls int
+}
+
+func (s *countingSampler) IsSampled(id TraceID, operation string) (bool, []Tag) {
+	s.calls++
+	return true, nil
+}
+
+func (s *countingSampler) Close() {}
+
+func (s *countingSampler) Equal(other Sampler) bool {
+	o, ok := other.(*countingSampler)
+	return ok && o == s
+}
+
+func TestOperationNameLateBinding_IsSampledDoesNotConsumeBudget(t *testing.T) {
+	inner := &countingSampler{}
+	sampler := &RemotelyControlledSampler{
+		samplerOptions: samplerOptions{
+			operationNameLateBinding: true,
+			sampler:                  inner,
+			logger:                   log.NullLogger,
+		},
+	}
+
+	sampled, tags := sampler.IsSampled(TraceID{Low: 1}, "")
+	assert.True(t, sampled)
+	assert.Nil(t, tags)
+	assert.Equal(t, 0, inner.calls, "IsSampled must not consume the per-operation budget while the operation name is not yet known")
+
+	sampled, _ = sampler.OnSetOperationName(TraceID{Low: 1}, "real-operation")
+	assert.True(t, sampled)
+	assert.Equal(t, 1, inner.calls, "OnSetOperationName is the only call site that should make the authoritative, budget-consuming decision")
+}
+
+func TestOperationNameLateBinding_KnownOperationNameIsNotProvisional(t *testing.T) {
+	inner := &countingSampler{}
+	sampler := &RemotelyControlledSampler{
+		samplerOptions: samplerOptions{
+			operationNameLateBinding: true,
+			sampler:                  inner,
+			logger:                   log.NullLogger,
+		},
+	}
+
+	// A span whose operation name is already known must go through the normal,
+	// budget-consuming path even though late binding is enabled on the sampler: only
+	// calls made with DeferredOperationName are provisional.
+	sampled, _ := sampler.IsSampled(TraceID{Low: 1}, "known-operation")
+	assert.True(t, sampled)
+	assert.Equal(t, 1, inner.calls, "IsSampled with a known operation name must not take the provisional shortcut")
+}
+
+func TestServerLateBinding_NotLatchedAcrossPolls(t *testing.T) {
+	inner := &countingSampler{}
+	sampler := &RemotelyControlledSampler{
+		samplerOptions: samplerOptions{
+			sampler: inner,
+			logger:  log.NullLogger,
+		},
+	}
+
+	sampler.updateAdaptiveSampler(&sampling.PerOperationSamplingStrategies{
+		DefaultSamplingProbability: 1.0,
+		OperationNameLateBinding:   true,
+	})
+	sampled, _ := sampler.OnSetOperationName(TraceID{Low: 1}, "op")
+	assert.True(t, sampled, "late binding enabled by the server should be honored")
+
+	// A later poll that reports the directive as off must not be stuck on forever.
+	sampler.updateAdaptiveSampler(&sampling.PerOperationSamplingStrategies{
+		DefaultSamplingProbability: 1.0,
+		OperationNameLateBinding:   false,
+	})
+	sampled, tags := sampler.OnSetOperationName(TraceID{Low: 1}, "op")
+	assert.False(t, sampled)
+	assert.Nil(t, tags)
+}
+
+func TestOperationNameLateBinding_Disabled(t *testing.T) {
+	inner := &countingSampler{}
+	sampler := &RemotelyControlledSampler{
+		samplerOptions: samplerOptions{
+			sampler: inner,
+			logger:  log.NullLogger,
+		},
+	}
+
+	sampler.IsSampled(TraceID{Low: 1}, "operation")
+	assert.Equal(t, 1, inner.calls, "without late binding, IsSampled makes the decision directly")
+
+	sampled, tags := sampler.OnSetOperationName(TraceID{Low: 1}, "operation")
+	assert.False(t, sampled)
+	assert.Nil(t, tags)
+	assert.Equal(t, 1, inner.calls, "OnSetOperationName is a no-op when late binding was not enabled")
+}